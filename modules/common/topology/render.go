@@ -0,0 +1,161 @@
+/*
+Copyright 2025 Red Hat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topology
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// matchLabelKeysMinorVersion is the Kubernetes minor version (1.x) that
+// MatchLabelKeys/MismatchLabelKeys for Pod(Anti)Affinity, and MatchLabelKeys
+// for TopologySpreadConstraints, first ship natively in:
+// https://kubernetes.io/blog/2024/08/16/matchlabelkeys-podaffinity/
+const matchLabelKeysMinorVersion = 31
+
+// RenderAffinityForPodTemplate returns a deep copy of affinity with
+// MatchLabelKeys/MismatchLabelKeys translated into plain LabelSelector
+// requirements on clusters older than matchLabelKeysMinorVersion, so
+// services can author MatchLabelKeys-style topology specs today and keep
+// working across the whole supported version matrix. On 1.31+ clusters
+// affinity is returned unmodified (aside from the deep copy).
+func RenderAffinityForPodTemplate(
+	affinity *corev1.Affinity,
+	podLabels map[string]string,
+	serverVersion *version.Info,
+) *corev1.Affinity {
+
+	if affinity == nil {
+		return nil
+	}
+
+	rendered := affinity.DeepCopy()
+
+	if supportsMatchLabelKeys(serverVersion) {
+		return rendered
+	}
+
+	if podAffinity := rendered.PodAffinity; podAffinity != nil {
+		renderPodAffinityTerms(podAffinity.RequiredDuringSchedulingIgnoredDuringExecution, podLabels)
+		for i := range podAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			renderPodAffinityTerm(&podAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm, podLabels)
+		}
+	}
+	if podAntiAffinity := rendered.PodAntiAffinity; podAntiAffinity != nil {
+		renderPodAffinityTerms(podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, podLabels)
+		for i := range podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			renderPodAffinityTerm(&podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm, podLabels)
+		}
+	}
+
+	return rendered
+}
+
+// RenderTopologySpreadConstraintsForPodTemplate applies the same
+// MatchLabelKeys pre-1.31 translation as RenderAffinityForPodTemplate to a
+// slice of TopologySpreadConstraints, returning a deep copy.
+// TopologySpreadConstraint has no MismatchLabelKeys field — that's a
+// PodAffinityTerm-only field — so only MatchLabelKeys is translated here.
+func RenderTopologySpreadConstraintsForPodTemplate(
+	constraints []corev1.TopologySpreadConstraint,
+	podLabels map[string]string,
+	serverVersion *version.Info,
+) []corev1.TopologySpreadConstraint {
+
+	if constraints == nil {
+		return nil
+	}
+
+	rendered := make([]corev1.TopologySpreadConstraint, len(constraints))
+	for i := range constraints {
+		rendered[i] = *constraints[i].DeepCopy()
+	}
+
+	if supportsMatchLabelKeys(serverVersion) {
+		return rendered
+	}
+
+	for i := range rendered {
+		renderMatchLabelKeys(&rendered[i].LabelSelector, rendered[i].MatchLabelKeys, podLabels, false)
+		rendered[i].MatchLabelKeys = nil
+	}
+
+	return rendered
+}
+
+func supportsMatchLabelKeys(serverVersion *version.Info) bool {
+	if serverVersion == nil {
+		return false
+	}
+	major, err := strconv.Atoi(strings.TrimSuffix(serverVersion.Major, "+"))
+	if err != nil || major != 1 {
+		return false
+	}
+	minor, err := strconv.Atoi(strings.TrimSuffix(serverVersion.Minor, "+"))
+	if err != nil {
+		return false
+	}
+	return minor >= matchLabelKeysMinorVersion
+}
+
+func renderPodAffinityTerms(terms []corev1.PodAffinityTerm, podLabels map[string]string) {
+	for i := range terms {
+		renderPodAffinityTerm(&terms[i], podLabels)
+	}
+}
+
+func renderPodAffinityTerm(term *corev1.PodAffinityTerm, podLabels map[string]string) {
+	renderMatchLabelKeys(&term.LabelSelector, term.MatchLabelKeys, podLabels, false)
+	term.MatchLabelKeys = nil
+	renderMatchLabelKeys(&term.LabelSelector, term.MismatchLabelKeys, podLabels, true)
+	term.MismatchLabelKeys = nil
+}
+
+// renderMatchLabelKeys expands keys into extra LabelSelectorRequirements on
+// *selector (creating it if necessary): In when mismatch is false, NotIn
+// when mismatch is true, with the value taken from podLabels[key]. Keys
+// absent from podLabels are skipped, matching how the native field behaves
+// when a pod doesn't carry the key yet.
+func renderMatchLabelKeys(selector **metav1.LabelSelector, keys []string, podLabels map[string]string, mismatch bool) {
+	if len(keys) == 0 {
+		return
+	}
+
+	op := metav1.LabelSelectorOpIn
+	if mismatch {
+		op = metav1.LabelSelectorOpNotIn
+	}
+
+	for _, key := range keys {
+		value, ok := podLabels[key]
+		if !ok {
+			continue
+		}
+		if *selector == nil {
+			*selector = &metav1.LabelSelector{}
+		}
+		(*selector).MatchExpressions = append((*selector).MatchExpressions, metav1.LabelSelectorRequirement{
+			Key:      key,
+			Operator: op,
+			Values:   []string{value},
+		})
+	}
+}
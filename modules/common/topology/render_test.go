@@ -0,0 +1,173 @@
+/*
+Copyright 2025 Red Hat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topology
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestSupportsMatchLabelKeys(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverVersion *version.Info
+		want          bool
+	}{
+		{"nil version", nil, false},
+		{"1.30 is too old", &version.Info{Major: "1", Minor: "30"}, false},
+		{"1.31 supports it", &version.Info{Major: "1", Minor: "31"}, true},
+		{"1.32 supports it", &version.Info{Major: "1", Minor: "32"}, true},
+		{"1.31+ suffix (EKS/OCP style) supports it", &version.Info{Major: "1", Minor: "31+"}, true},
+		{"major with + suffix", &version.Info{Major: "1+", Minor: "31"}, true},
+		{"non-numeric minor", &version.Info{Major: "1", Minor: "abc"}, false},
+		{"major 2 is not major 1", &version.Info{Major: "2", Minor: "0"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := supportsMatchLabelKeys(tt.serverVersion); got != tt.want {
+				t.Errorf("supportsMatchLabelKeys(%+v) = %v, want %v", tt.serverVersion, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderAffinityForPodTemplate(t *testing.T) {
+	podLabels := map[string]string{
+		StatefulsetMatchLabelKey: "abc123",
+		"app":                    "galera",
+	}
+
+	newAffinity := func() *corev1.Affinity {
+		return &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{MatchLabelKeys: []string{"app"}},
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{PodAffinityTerm: corev1.PodAffinityTerm{MismatchLabelKeys: []string{StatefulsetMatchLabelKey}}},
+				},
+			},
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{MatchLabelKeys: []string{"app"}, MismatchLabelKeys: []string{"missing-key"}},
+				},
+			},
+		}
+	}
+
+	t.Run("nil affinity returns nil", func(t *testing.T) {
+		if got := RenderAffinityForPodTemplate(nil, podLabels, &version.Info{Major: "1", Minor: "30"}); got != nil {
+			t.Errorf("got %+v, want nil", got)
+		}
+	})
+
+	t.Run("1.31+ leaves MatchLabelKeys/MismatchLabelKeys untouched", func(t *testing.T) {
+		affinity := newAffinity()
+		got := RenderAffinityForPodTemplate(affinity, podLabels, &version.Info{Major: "1", Minor: "31"})
+		if !reflect.DeepEqual(got, affinity) {
+			t.Errorf("got %+v, want unmodified %+v", got, affinity)
+		}
+	})
+
+	t.Run("pre-1.31 expands MatchLabelKeys/MismatchLabelKeys and clears them", func(t *testing.T) {
+		affinity := newAffinity()
+		got := RenderAffinityForPodTemplate(affinity, podLabels, &version.Info{Major: "1", Minor: "30"})
+
+		required := got.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+		if required.MatchLabelKeys != nil {
+			t.Errorf("required.MatchLabelKeys = %v, want nil", required.MatchLabelKeys)
+		}
+		wantReq := []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"galera"}},
+		}
+		if required.LabelSelector == nil || !reflect.DeepEqual(required.LabelSelector.MatchExpressions, wantReq) {
+			t.Errorf("required.LabelSelector = %+v, want MatchExpressions %+v", required.LabelSelector, wantReq)
+		}
+
+		preferred := got.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm
+		if preferred.MismatchLabelKeys != nil {
+			t.Errorf("preferred.MismatchLabelKeys = %v, want nil", preferred.MismatchLabelKeys)
+		}
+		wantPreferred := []metav1.LabelSelectorRequirement{
+			{Key: StatefulsetMatchLabelKey, Operator: metav1.LabelSelectorOpNotIn, Values: []string{"abc123"}},
+		}
+		if preferred.LabelSelector == nil || !reflect.DeepEqual(preferred.LabelSelector.MatchExpressions, wantPreferred) {
+			t.Errorf("preferred.LabelSelector = %+v, want MatchExpressions %+v", preferred.LabelSelector, wantPreferred)
+		}
+
+		// a key with no matching pod label is skipped rather than emitting a
+		// requirement with an empty value
+		antiRequired := got.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0]
+		if antiRequired.MatchLabelKeys != nil || antiRequired.MismatchLabelKeys != nil {
+			t.Errorf("anti-affinity MatchLabelKeys/MismatchLabelKeys not cleared: %+v", antiRequired)
+		}
+		wantAntiRequired := []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"galera"}},
+		}
+		if antiRequired.LabelSelector == nil || !reflect.DeepEqual(antiRequired.LabelSelector.MatchExpressions, wantAntiRequired) {
+			t.Errorf("antiRequired.LabelSelector = %+v, want MatchExpressions %+v", antiRequired.LabelSelector, wantAntiRequired)
+		}
+
+		// the input is untouched since RenderAffinityForPodTemplate deep-copies
+		if affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].MatchLabelKeys == nil {
+			t.Errorf("input affinity was mutated in place")
+		}
+	})
+}
+
+func TestRenderTopologySpreadConstraintsForPodTemplate(t *testing.T) {
+	podLabels := map[string]string{"app": "galera"}
+
+	t.Run("nil constraints returns nil", func(t *testing.T) {
+		if got := RenderTopologySpreadConstraintsForPodTemplate(nil, podLabels, &version.Info{Major: "1", Minor: "30"}); got != nil {
+			t.Errorf("got %+v, want nil", got)
+		}
+	})
+
+	constraints := []corev1.TopologySpreadConstraint{
+		{TopologyKey: "kubernetes.io/hostname", MatchLabelKeys: []string{"app"}},
+	}
+
+	t.Run("1.31+ leaves MatchLabelKeys untouched", func(t *testing.T) {
+		got := RenderTopologySpreadConstraintsForPodTemplate(constraints, podLabels, &version.Info{Major: "1", Minor: "31"})
+		if !reflect.DeepEqual(got, constraints) {
+			t.Errorf("got %+v, want unmodified %+v", got, constraints)
+		}
+	})
+
+	t.Run("pre-1.31 expands MatchLabelKeys and clears it", func(t *testing.T) {
+		got := RenderTopologySpreadConstraintsForPodTemplate(constraints, podLabels, &version.Info{Major: "1", Minor: "30"})
+		if got[0].MatchLabelKeys != nil {
+			t.Errorf("MatchLabelKeys = %v, want nil", got[0].MatchLabelKeys)
+		}
+		want := []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"galera"}},
+		}
+		if got[0].LabelSelector == nil || !reflect.DeepEqual(got[0].LabelSelector.MatchExpressions, want) {
+			t.Errorf("LabelSelector = %+v, want MatchExpressions %+v", got[0].LabelSelector, want)
+		}
+		// the input is untouched since the function deep-copies
+		if constraints[0].MatchLabelKeys == nil {
+			t.Errorf("input constraints were mutated in place")
+		}
+	})
+}
@@ -18,11 +18,13 @@ package topology
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	topologyv1 "github.com/openstack-k8s-operators/infra-operator/apis/topology/v1beta1"
 	"github.com/openstack-k8s-operators/lib-common/modules/common/helper"
 	"github.com/openstack-k8s-operators/lib-common/modules/common/util"
+	corev1 "k8s.io/api/core/v1"
 	k8s_errors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -30,12 +32,68 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
+// ErrInvalidTopologySpec is the sentinel wrapped by validation errors
+// returned from EnsureTopologyRef, so callers can recognize a malformed
+// Topology (as opposed to a transient client error) with errors.Is and
+// surface it as a Condition rather than retrying
+var ErrInvalidTopologySpec = errors.New("invalid topology spec")
+
 // Standard MatchLabelKeys labels for Deployments/Statefulsets
 const (
 	StatefulsetMatchLabelKey = "controller-revision-hash"
 	DeploymentMatchLabelKey  = "pod-template-hash"
 )
 
+// finalizerPatchRetries is the number of times we retry a finalizer patch
+// on a resource-version conflict before giving up
+const finalizerPatchRetries = 3
+
+// patchFinalizer applies a merge patch that only touches metadata.finalizers
+// on topology, retrying on conflict by re-fetching the object and re-applying
+// mutate. It returns after a successful patch, once mutate reports no further
+// change is needed, on a Get or non-conflict Patch error, or once the retries
+// are exhausted.
+func patchFinalizer(
+	ctx context.Context,
+	h *helper.Helper,
+	c client.Client,
+	topologyRef *Topology,
+	mutate func(*topologyv1.Topology) bool,
+) (*topologyv1.Topology, error) {
+
+	var topology *topologyv1.Topology
+	var err error
+
+	for i := 0; i < finalizerPatchRetries; i++ {
+		topology, _, err = topologyv1.GetTopologyByName(
+			ctx,
+			h,
+			topologyRef.Name,
+			topologyRef.Namespace,
+		)
+		if err != nil {
+			return topology, err
+		}
+
+		orig := topology.DeepCopy()
+		if !mutate(topology) {
+			// nothing to do, finalizer already in the desired state
+			return topology, nil
+		}
+
+		patch := client.MergeFromWithOptions(orig, client.MergeFromWithOptimisticLock{})
+		err = c.Patch(ctx, topology, patch)
+		if err == nil {
+			return topology, nil
+		}
+		if !k8s_errors.IsConflict(err) {
+			return topology, err
+		}
+	}
+
+	return topology, err
+}
+
 // EnsureTopologyRef - retrieve the Topology CR referenced and add a finalizer
 func EnsureTopologyRef(
 	ctx context.Context,
@@ -44,6 +102,9 @@ func EnsureTopologyRef(
 	finalizer string,
 	defaultLabelSelector metav1.LabelSelector,
 	defaultMatchLabelKeys []string,
+	defaultMinDomains *int32,
+	defaultNamespaces []string,
+	defaultNamespaceSelector *metav1.LabelSelector,
 
 ) (*topologyv1.Topology, string, error) {
 
@@ -55,26 +116,38 @@ func EnsureTopologyRef(
 		return nil, "", fmt.Errorf("No valid TopologyRef input passed")
 	}
 
-	topology, _, err := topologyv1.GetTopologyByName(
+	finalizerName := fmt.Sprintf("%s-%s", h.GetFinalizer(), finalizer)
+
+	// Add finalizer (if not present) to the resource consumed by the Service.
+	// This is done via a resource-version-scoped merge patch, rather than a
+	// full Update, so it is asserted every reconcile even if a concurrent
+	// writer (or a user) stripped it between reconciles.
+	topology, err := patchFinalizer(
 		ctx,
 		h,
-		topologyRef.Name,
-		topologyRef.Namespace,
+		h.GetClient(),
+		topologyRef,
+		func(t *topologyv1.Topology) bool {
+			return controllerutil.AddFinalizer(t, finalizerName)
+		},
 	)
 	if err != nil {
 		return topology, hash, err
 	}
 
-	// Add finalizer (if not present) to the resource consumed by the Service
-	if controllerutil.AddFinalizer(topology, fmt.Sprintf("%s-%s", h.GetFinalizer(), finalizer)) {
-		if err := h.GetClient().Update(ctx, topology); err != nil {
-			return topology, hash, err
-		}
+	// Guard against the patch silently not taking effect (e.g. a webhook
+	// stripping the finalizer back out) so a partially-deleted Topology
+	// can't be observed by a consumer without its finalizer re-established
+	if !controllerutil.ContainsFinalizer(topology, finalizerName) {
+		return topology, hash, fmt.Errorf("finalizer %s not present on Topology %s/%s after patch",
+			finalizerName, topology.Namespace, topology.Name)
 	}
 
 	// Set default LabelSelector and MatchLabelKeys if applicable
 	topology = topology.DeepCopy()
 
+	defaultNodeInclusionPolicy := corev1.NodeInclusionPolicyHonor
+
 	topologyConstraints := topology.Spec.TopologySpreadConstraints
 	if topologyConstraints != nil {
 		for i := 0; i < len(*topologyConstraints); i++ {
@@ -85,6 +158,15 @@ func EnsureTopologyRef(
 			if len(current.MatchLabelKeys) == 0 {
 				current.MatchLabelKeys = defaultMatchLabelKeys
 			}
+			if current.MinDomains == nil && current.WhenUnsatisfiable == corev1.DoNotSchedule {
+				current.MinDomains = defaultMinDomains
+			}
+			if current.NodeAffinityPolicy == nil {
+				current.NodeAffinityPolicy = &defaultNodeInclusionPolicy
+			}
+			if current.NodeTaintsPolicy == nil {
+				current.NodeTaintsPolicy = &defaultNodeInclusionPolicy
+			}
 		}
 	}
 	affinity := topology.Spec.Affinity
@@ -101,6 +183,7 @@ func EnsureTopologyRef(
 				if len(current.MatchLabelKeys) == 0 {
 					current.MatchLabelKeys = defaultMatchLabelKeys
 				}
+				defaultPodAffinityTermNamespaces(current, defaultNamespaces, defaultNamespaceSelector)
 			}
 			for i := range podAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
 				current := &podAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm
@@ -112,6 +195,7 @@ func EnsureTopologyRef(
 				if len(current.MatchLabelKeys) == 0 {
 					current.MatchLabelKeys = defaultMatchLabelKeys
 				}
+				defaultPodAffinityTermNamespaces(current, defaultNamespaces, defaultNamespaceSelector)
 			}
 		}
 		podAntiAffinity := affinity.PodAntiAffinity
@@ -126,6 +210,7 @@ func EnsureTopologyRef(
 				if len(current.MatchLabelKeys) == 0 {
 					current.MatchLabelKeys = defaultMatchLabelKeys
 				}
+				defaultPodAffinityTermNamespaces(current, defaultNamespaces, defaultNamespaceSelector)
 			}
 			for i := range podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
 				current := &podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[i].PodAffinityTerm
@@ -137,10 +222,15 @@ func EnsureTopologyRef(
 				if len(current.MatchLabelKeys) == 0 {
 					current.MatchLabelKeys = defaultMatchLabelKeys
 				}
+				defaultPodAffinityTermNamespaces(current, defaultNamespaces, defaultNamespaceSelector)
 			}
 		}
 	}
 
+	if err := validateTopologySpec(&topology.Spec); err != nil {
+		return topology, hash, err
+	}
+
 	hash, err = util.ObjectHash(topology.Spec)
 	if err != nil {
 		return topology, hash, err
@@ -164,25 +254,116 @@ func EnsureDeletedTopologyRef(
 		return ctrl.Result{}, nil
 	}
 
-	// Remove the finalizer from the Topology CR
-	topology, _, err := topologyv1.GetTopologyByName(
+	// Remove the finalizer from the Topology CR via a merge patch, scoped to
+	// metadata.finalizers, retrying on conflict rather than risking a full
+	// Update clobbering a concurrent writer
+	finalizerName := fmt.Sprintf("%s-%s", h.GetFinalizer(), finalizer)
+	topology, err := patchFinalizer(
 		ctx,
 		h,
-		topologyRef.Name,
-		topologyRef.Namespace,
+		c,
+		topologyRef,
+		func(t *topologyv1.Topology) bool {
+			return controllerutil.RemoveFinalizer(t, finalizerName)
+		},
 	)
-
 	if err != nil && !k8s_errors.IsNotFound(err) {
 		return ctrl.Result{}, err
 	}
 	if !k8s_errors.IsNotFound(err) {
-		if controllerutil.RemoveFinalizer(topology, fmt.Sprintf("%s-%s", h.GetFinalizer(), finalizer)) {
-			err = c.Update(ctx, topology)
-			if err != nil && !k8s_errors.IsNotFound(err) {
-				return ctrl.Result{}, err
+		util.LogForObject(h, "Removed finalizer from Topology", topology)
+	}
+	return ctrl.Result{}, nil
+}
+
+// validateTopologySpec rejects a TopologySpec that the scheduler would
+// either reject outright or silently misinterpret: an empty TopologyKey,
+// a MaxSkew below 1, or a LabelSelector that metav1.LabelSelectorAsSelector
+// can't parse, on any TopologySpreadConstraint or any required/preferred
+// PodAffinityTerm under Affinity.Pod{,Anti}Affinity
+func validateTopologySpec(spec *topologyv1.TopologySpec) error {
+	if constraints := spec.TopologySpreadConstraints; constraints != nil {
+		for i := range *constraints {
+			if err := validateTopologySpreadConstraint(&(*constraints)[i]); err != nil {
+				return fmt.Errorf("%w: topologySpreadConstraints[%d]: %s", ErrInvalidTopologySpec, i, err)
 			}
-			util.LogForObject(h, "Removed finalizer from Topology", topology)
 		}
 	}
-	return ctrl.Result{}, nil
+
+	affinity := spec.Affinity
+	if affinity == nil {
+		return nil
+	}
+	if podAffinity := affinity.PodAffinity; podAffinity != nil {
+		if err := validatePodAffinityTerms("podAffinity", podAffinity.RequiredDuringSchedulingIgnoredDuringExecution, podAffinity.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return err
+		}
+	}
+	if podAntiAffinity := affinity.PodAntiAffinity; podAntiAffinity != nil {
+		if err := validatePodAffinityTerms("podAntiAffinity", podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateTopologySpreadConstraint(c *corev1.TopologySpreadConstraint) error {
+	if c.TopologyKey == "" {
+		return fmt.Errorf("topologyKey must not be empty")
+	}
+	if c.MaxSkew < 1 {
+		return fmt.Errorf("maxSkew %d must be >= 1", c.MaxSkew)
+	}
+	return validateLabelSelector(c.LabelSelector)
+}
+
+func validatePodAffinityTerms(
+	field string,
+	required []corev1.PodAffinityTerm,
+	preferred []corev1.WeightedPodAffinityTerm,
+) error {
+	for i := range required {
+		if required[i].TopologyKey == "" {
+			return fmt.Errorf("%w: %s.requiredDuringSchedulingIgnoredDuringExecution[%d]: topologyKey must not be empty",
+				ErrInvalidTopologySpec, field, i)
+		}
+		if err := validateLabelSelector(required[i].LabelSelector); err != nil {
+			return fmt.Errorf("%w: %s.requiredDuringSchedulingIgnoredDuringExecution[%d]: %s",
+				ErrInvalidTopologySpec, field, i, err)
+		}
+	}
+	for i := range preferred {
+		if preferred[i].PodAffinityTerm.TopologyKey == "" {
+			return fmt.Errorf("%w: %s.preferredDuringSchedulingIgnoredDuringExecution[%d]: topologyKey must not be empty",
+				ErrInvalidTopologySpec, field, i)
+		}
+		if err := validateLabelSelector(preferred[i].PodAffinityTerm.LabelSelector); err != nil {
+			return fmt.Errorf("%w: %s.preferredDuringSchedulingIgnoredDuringExecution[%d]: %s",
+				ErrInvalidTopologySpec, field, i, err)
+		}
+	}
+	return nil
+}
+
+// defaultPodAffinityTermNamespaces sets Namespaces/NamespaceSelector on term
+// when both are left unset, so it matches across defaultNamespaces instead
+// of implicitly falling back to the pod's own namespace
+func defaultPodAffinityTermNamespaces(
+	term *corev1.PodAffinityTerm,
+	defaultNamespaces []string,
+	defaultNamespaceSelector *metav1.LabelSelector,
+) {
+	if len(term.Namespaces) == 0 && term.NamespaceSelector == nil {
+		term.Namespaces = defaultNamespaces
+		term.NamespaceSelector = defaultNamespaceSelector
+	}
+}
+
+func validateLabelSelector(selector *metav1.LabelSelector) error {
+	if selector == nil {
+		return nil
+	}
+	_, err := metav1.LabelSelectorAsSelector(selector)
+	return err
 }
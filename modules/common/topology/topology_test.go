@@ -0,0 +1,416 @@
+/*
+Copyright 2025 Red Hat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topology
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	topologyv1 "github.com/openstack-k8s-operators/infra-operator/apis/topology/v1beta1"
+	"github.com/openstack-k8s-operators/lib-common/modules/common/helper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestTopologyHelper builds a fake-client-backed helper.Helper seeded
+// with top, for exercising EnsureTopologyRef/EnsureDeletedTopologyRef
+// against a realistic object instead of hand-rolling the production loops
+func newTestTopologyHelper(t *testing.T, top *topologyv1.Topology) *helper.Helper {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to add corev1 to scheme: %v", err)
+	}
+	if err := topologyv1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unable to add topologyv1 to scheme: %v", err)
+	}
+
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(top).Build()
+
+	h, err := helper.NewHelper(top, fakeClient, nil, scheme, logr.Discard())
+	if err != nil {
+		t.Fatalf("unable to create helper: %v", err)
+	}
+	return h
+}
+
+func TestDefaultPodAffinityTermNamespaces(t *testing.T) {
+	defaultNamespaces := []string{"openstack"}
+	defaultNamespaceSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"control-plane": "true"},
+	}
+
+	tests := []struct {
+		name string
+		term corev1.PodAffinityTerm
+		want corev1.PodAffinityTerm
+	}{
+		{
+			name: "both unset get defaulted",
+			term: corev1.PodAffinityTerm{},
+			want: corev1.PodAffinityTerm{
+				Namespaces:        defaultNamespaces,
+				NamespaceSelector: defaultNamespaceSelector,
+			},
+		},
+		{
+			name: "pre-set Namespaces is left untouched",
+			term: corev1.PodAffinityTerm{Namespaces: []string{"other"}},
+			want: corev1.PodAffinityTerm{Namespaces: []string{"other"}},
+		},
+		{
+			name: "pre-set NamespaceSelector is left untouched",
+			term: corev1.PodAffinityTerm{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"foo": "bar"}},
+			},
+			want: corev1.PodAffinityTerm{
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"foo": "bar"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := tt.term
+			defaultPodAffinityTermNamespaces(&term, defaultNamespaces, defaultNamespaceSelector)
+			if !reflect.DeepEqual(term, tt.want) {
+				t.Errorf("got %+v, want %+v", term, tt.want)
+			}
+		})
+	}
+}
+
+// newAffinityWithEmptyTerms returns an Affinity with one unset PodAffinityTerm
+// in each of the four required/preferred PodAffinity/PodAntiAffinity lists
+func newAffinityWithEmptyTerms() *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAffinity: &corev1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{TopologyKey: "kubernetes.io/hostname"},
+			},
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{Weight: 1, PodAffinityTerm: corev1.PodAffinityTerm{TopologyKey: "kubernetes.io/hostname"}},
+			},
+		},
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+				{TopologyKey: "kubernetes.io/hostname"},
+			},
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{Weight: 1, PodAffinityTerm: corev1.PodAffinityTerm{TopologyKey: "kubernetes.io/hostname"}},
+			},
+		},
+	}
+}
+
+// TestEnsureTopologyRefDefaultsNamespacesOnAllTermLists drives the real
+// EnsureTopologyRef defaulting loop (not a hand-rolled copy of it) against a
+// Topology fixture with all four PodAffinity/PodAntiAffinity
+// required/preferred term lists populated, and asserts the returned spec
+// carries the Namespaces/NamespaceSelector defaults on every one of them
+func TestEnsureTopologyRefDefaultsNamespacesOnAllTermLists(t *testing.T) {
+	defaultNamespaces := []string{"openstack"}
+	defaultNamespaceSelector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"control-plane": "true"},
+	}
+
+	top := &topologyv1.Topology{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-topology", Namespace: "openstack"},
+		Spec:       topologyv1.TopologySpec{Affinity: newAffinityWithEmptyTerms()},
+	}
+
+	h := newTestTopologyHelper(t, top)
+	topologyRef := &Topology{Name: top.Name, Namespace: top.Namespace}
+
+	got, _, err := EnsureTopologyRef(
+		context.Background(),
+		h,
+		topologyRef,
+		"test-finalizer",
+		metav1.LabelSelector{},
+		nil,
+		nil,
+		defaultNamespaces,
+		defaultNamespaceSelector,
+	)
+	if err != nil {
+		t.Fatalf("EnsureTopologyRef() error = %v", err)
+	}
+
+	terms := []corev1.PodAffinityTerm{
+		got.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0],
+		got.Spec.Affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm,
+		got.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0],
+		got.Spec.Affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution[0].PodAffinityTerm,
+	}
+	names := []string{
+		"podAffinity.required", "podAffinity.preferred",
+		"podAntiAffinity.required", "podAntiAffinity.preferred",
+	}
+	for i, term := range terms {
+		if !reflect.DeepEqual(term.Namespaces, defaultNamespaces) {
+			t.Errorf("%s: Namespaces = %v, want %v", names[i], term.Namespaces, defaultNamespaces)
+		}
+		if !reflect.DeepEqual(term.NamespaceSelector, defaultNamespaceSelector) {
+			t.Errorf("%s: NamespaceSelector = %v, want %v", names[i], term.NamespaceSelector, defaultNamespaceSelector)
+		}
+	}
+}
+
+func int32Ptr(v int32) *int32 { return &v }
+
+func nodeInclusionPolicyPtr(v corev1.NodeInclusionPolicy) *corev1.NodeInclusionPolicy { return &v }
+
+func TestValidateTopologySpreadConstraint(t *testing.T) {
+	validSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "galera"}}
+	invalidSelector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: "NotAnOperator"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		c       corev1.TopologySpreadConstraint
+		wantErr bool
+	}{
+		{
+			name:    "valid constraint",
+			c:       corev1.TopologySpreadConstraint{TopologyKey: "kubernetes.io/hostname", MaxSkew: 1, LabelSelector: validSelector},
+			wantErr: false,
+		},
+		{
+			name:    "empty TopologyKey is rejected",
+			c:       corev1.TopologySpreadConstraint{MaxSkew: 1, LabelSelector: validSelector},
+			wantErr: true,
+		},
+		{
+			name:    "MaxSkew 0 is rejected",
+			c:       corev1.TopologySpreadConstraint{TopologyKey: "kubernetes.io/hostname", MaxSkew: 0, LabelSelector: validSelector},
+			wantErr: true,
+		},
+		{
+			name:    "MaxSkew 1 is accepted",
+			c:       corev1.TopologySpreadConstraint{TopologyKey: "kubernetes.io/hostname", MaxSkew: 1, LabelSelector: validSelector},
+			wantErr: false,
+		},
+		{
+			name:    "invalid LabelSelector is rejected",
+			c:       corev1.TopologySpreadConstraint{TopologyKey: "kubernetes.io/hostname", MaxSkew: 1, LabelSelector: invalidSelector},
+			wantErr: true,
+		},
+		{
+			name:    "nil LabelSelector is accepted",
+			c:       corev1.TopologySpreadConstraint{TopologyKey: "kubernetes.io/hostname", MaxSkew: 1},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTopologySpreadConstraint(&tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTopologySpreadConstraint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTopologySpec(t *testing.T) {
+	validSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "galera"}}
+	invalidSelector := &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "app", Operator: "NotAnOperator"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		spec    topologyv1.TopologySpec
+		wantErr bool
+	}{
+		{
+			name:    "empty spec is valid",
+			spec:    topologyv1.TopologySpec{},
+			wantErr: false,
+		},
+		{
+			name: "invalid TopologySpreadConstraint is rejected",
+			spec: topologyv1.TopologySpec{
+				TopologySpreadConstraints: &[]corev1.TopologySpreadConstraint{
+					{MaxSkew: 1, LabelSelector: validSelector},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid required PodAffinityTerm LabelSelector is rejected",
+			spec: topologyv1.TopologySpec{
+				Affinity: &corev1.Affinity{
+					PodAffinity: &corev1.PodAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+							{TopologyKey: "kubernetes.io/hostname", LabelSelector: invalidSelector},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid preferred PodAntiAffinityTerm LabelSelector is rejected",
+			spec: topologyv1.TopologySpec{
+				Affinity: &corev1.Affinity{
+					PodAntiAffinity: &corev1.PodAntiAffinity{
+						PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+							{Weight: 1, PodAffinityTerm: corev1.PodAffinityTerm{
+								TopologyKey:   "kubernetes.io/hostname",
+								LabelSelector: invalidSelector,
+							}},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid spec with both spread constraints and affinity",
+			spec: topologyv1.TopologySpec{
+				TopologySpreadConstraints: &[]corev1.TopologySpreadConstraint{
+					{TopologyKey: "kubernetes.io/hostname", MaxSkew: 1, LabelSelector: validSelector},
+				},
+				Affinity: &corev1.Affinity{
+					PodAffinity: &corev1.PodAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+							{TopologyKey: "kubernetes.io/hostname", LabelSelector: validSelector},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTopologySpec(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTopologySpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestEnsureTopologyRefDefaultsMinDomainsAndNodePolicies drives
+// EnsureTopologyRef against TopologySpreadConstraints to confirm MinDomains
+// is only defaulted when WhenUnsatisfiable is DoNotSchedule, and that
+// NodeAffinityPolicy/NodeTaintsPolicy default to Honor
+func TestEnsureTopologyRefDefaultsMinDomainsAndNodePolicies(t *testing.T) {
+	defaultMinDomains := int32Ptr(3)
+
+	tests := []struct {
+		name             string
+		constraint       corev1.TopologySpreadConstraint
+		wantMinDomains   *int32
+		wantNodeAffinity corev1.NodeInclusionPolicy
+		wantNodeTaints   corev1.NodeInclusionPolicy
+	}{
+		{
+			name: "DoNotSchedule with unset MinDomains gets defaulted",
+			constraint: corev1.TopologySpreadConstraint{
+				TopologyKey: "kubernetes.io/hostname", MaxSkew: 1,
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+			},
+			wantMinDomains:   defaultMinDomains,
+			wantNodeAffinity: corev1.NodeInclusionPolicyHonor,
+			wantNodeTaints:   corev1.NodeInclusionPolicyHonor,
+		},
+		{
+			name: "ScheduleAnyway with unset MinDomains is left nil",
+			constraint: corev1.TopologySpreadConstraint{
+				TopologyKey: "kubernetes.io/hostname", MaxSkew: 1,
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+			},
+			wantMinDomains:   nil,
+			wantNodeAffinity: corev1.NodeInclusionPolicyHonor,
+			wantNodeTaints:   corev1.NodeInclusionPolicyHonor,
+		},
+		{
+			name: "pre-set MinDomains/policies are left untouched",
+			constraint: corev1.TopologySpreadConstraint{
+				TopologyKey: "kubernetes.io/hostname", MaxSkew: 1,
+				WhenUnsatisfiable:  corev1.DoNotSchedule,
+				MinDomains:         int32Ptr(7),
+				NodeAffinityPolicy: nodeInclusionPolicyPtr(corev1.NodeInclusionPolicyIgnore),
+				NodeTaintsPolicy:   nodeInclusionPolicyPtr(corev1.NodeInclusionPolicyIgnore),
+			},
+			wantMinDomains:   int32Ptr(7),
+			wantNodeAffinity: corev1.NodeInclusionPolicyIgnore,
+			wantNodeTaints:   corev1.NodeInclusionPolicyIgnore,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top := &topologyv1.Topology{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-topology", Namespace: "openstack"},
+				Spec: topologyv1.TopologySpec{
+					TopologySpreadConstraints: &[]corev1.TopologySpreadConstraint{tt.constraint},
+				},
+			}
+			h := newTestTopologyHelper(t, top)
+			topologyRef := &Topology{Name: top.Name, Namespace: top.Namespace}
+
+			got, _, err := EnsureTopologyRef(
+				context.Background(),
+				h,
+				topologyRef,
+				"test-finalizer",
+				metav1.LabelSelector{},
+				nil,
+				defaultMinDomains,
+				nil,
+				nil,
+			)
+			if err != nil {
+				t.Fatalf("EnsureTopologyRef() error = %v", err)
+			}
+
+			c := (*got.Spec.TopologySpreadConstraints)[0]
+			if !reflect.DeepEqual(c.MinDomains, tt.wantMinDomains) {
+				t.Errorf("MinDomains = %v, want %v", derefInt32(c.MinDomains), derefInt32(tt.wantMinDomains))
+			}
+			if c.NodeAffinityPolicy == nil || *c.NodeAffinityPolicy != tt.wantNodeAffinity {
+				t.Errorf("NodeAffinityPolicy = %v, want %v", c.NodeAffinityPolicy, tt.wantNodeAffinity)
+			}
+			if c.NodeTaintsPolicy == nil || *c.NodeTaintsPolicy != tt.wantNodeTaints {
+				t.Errorf("NodeTaintsPolicy = %v, want %v", c.NodeTaintsPolicy, tt.wantNodeTaints)
+			}
+		})
+	}
+}
+
+func derefInt32(v *int32) int32 {
+	if v == nil {
+		return -1
+	}
+	return *v
+}
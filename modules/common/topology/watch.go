@@ -0,0 +1,142 @@
+/*
+Copyright 2025 Red Hat
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package topology
+
+import (
+	"context"
+	"fmt"
+
+	topologyv1 "github.com/openstack-k8s-operators/infra-operator/apis/topology/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// topologyRefIndexValue builds the value a consumer's field indexer should
+// store for a given topologyRef, keeping the "namespace/name" format used by
+// the lookup inside WatchTopology in one place
+func topologyRefIndexValue(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// WatchTopology registers a field indexer on forType at indexField (read via
+// forType's GetTopologyRef() method, and stored as "<namespace>/<name>") and
+// returns a source.Source that re-enqueues every forType object referencing
+// a Topology whenever that Topology's spec changes; status-only updates are
+// filtered out by the source's predicate. Consumers wire it up as:
+//
+//	src, err := topology.WatchTopology(mgr, &MyCR{}, "spec.topologyRef")
+//	if err != nil {
+//		return err
+//	}
+//	return ctrl.NewControllerManagedBy(mgr).
+//		For(&MyCR{}).
+//		WatchesRawSource(src).
+//		Complete(r)
+func WatchTopology(
+	mgr manager.Manager,
+	forType client.Object,
+	indexField string,
+) (source.Source, error) {
+
+	indexer := func(obj client.Object) []string {
+		ref, ok := obj.(interface{ GetTopologyRef() *Topology })
+		if !ok || ref.GetTopologyRef() == nil || ref.GetTopologyRef().Name == "" {
+			return nil
+		}
+		t := ref.GetTopologyRef()
+		namespace := t.Namespace
+		if namespace == "" {
+			namespace = obj.GetNamespace()
+		}
+		return []string{topologyRefIndexValue(namespace, t.Name)}
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), forType, indexField, indexer); err != nil {
+		return nil, fmt.Errorf("unable to set up %s indexer for %T: %w", indexField, forType, err)
+	}
+
+	gvk, err := apiutil.GVKForObject(forType, mgr.GetScheme())
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve GroupVersionKind for %T: %w", forType, err)
+	}
+	listGVK := gvk
+	listGVK.Kind += "List"
+
+	mapFunc := func(ctx context.Context, t *topologyv1.Topology) []reconcile.Request {
+		logger := log.FromContext(ctx)
+
+		list, err := mgr.GetScheme().New(listGVK)
+		if err != nil {
+			logger.Error(err, "unable to construct list object for WatchTopology", "listGVK", listGVK)
+			return nil
+		}
+		objList, ok := list.(client.ObjectList)
+		if !ok {
+			logger.Error(fmt.Errorf("%T does not implement client.ObjectList", list),
+				"unable to list objects for WatchTopology", "listGVK", listGVK)
+			return nil
+		}
+
+		if err := mgr.GetClient().List(ctx, objList, client.MatchingFields{
+			indexField: topologyRefIndexValue(t.Namespace, t.Name),
+		}); err != nil {
+			logger.Error(err, "unable to list objects referencing Topology", "indexField", indexField,
+				"topology", client.ObjectKeyFromObject(t))
+			return nil
+		}
+
+		items, err := apimeta.ExtractList(objList)
+		if err != nil {
+			logger.Error(err, "unable to extract items from list for WatchTopology", "listGVK", listGVK)
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(items))
+		for _, item := range items {
+			o, ok := item.(client.Object)
+			if !ok {
+				continue
+			}
+			requests = append(requests, reconcile.Request{
+				NamespacedName: client.ObjectKeyFromObject(o),
+			})
+		}
+		return requests
+	}
+
+	src := source.Kind(
+		mgr.GetCache(),
+		&topologyv1.Topology{},
+		handler.TypedEnqueueRequestsFromMapFunc(mapFunc),
+		predicate.TypedFuncs[*topologyv1.Topology]{
+			UpdateFunc: func(e event.TypedUpdateEvent[*topologyv1.Topology]) bool {
+				return !equality.Semantic.DeepEqual(e.ObjectOld.Spec, e.ObjectNew.Spec)
+			},
+		},
+	)
+
+	return src, nil
+}